@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// sseCustomerKeyHeader / sseCustomerAlgorithmHeader 是客户端传递 SSE-C
+// 加密密钥的请求头，和 AWS S3 的约定保持一致
+const (
+	sseCustomerKeyHeader       = "X-Amz-Server-Side-Encryption-Customer-Key"
+	sseCustomerAlgorithmHeader = "X-Amz-Server-Side-Encryption-Customer-Algorithm"
+)
+
+// sseAlgorithmSSEC 是目前唯一支持的客户自有密钥算法
+const sseAlgorithmSSEC = "SSE-C"
+
+// encryptionRequest 是 initMultipartUpload 请求体里的 encryption 字段
+type encryptionRequest struct {
+	Algorithm string `json:"algorithm"`
+	Key       string `json:"key"`
+}
+
+// fingerprintSSECKey 返回 SSE-C 密钥的指纹（MD5，base64 编码），
+// 用于在不保存密钥明文的前提下校验后续请求携带的密钥是否一致
+func fingerprintSSECKey(rawKey []byte) string {
+	sum := md5.Sum(rawKey)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// sseCFromBase64Key 把 base64 编码的客户密钥转换为 SSE-C ServerSide，
+// 并返回对应的指纹
+func sseCFromBase64Key(keyB64 string) (encrypt.ServerSide, string, error) {
+	rawKey, err := base64.StdEncoding.DecodeString(keyB64)
+	if err != nil {
+		return nil, "", fmt.Errorf("无效的加密密钥编码")
+	}
+
+	sse, err := encrypt.NewSSEC(rawKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("无效的加密密钥: %v", err)
+	}
+
+	return sse, fingerprintSSECKey(rawKey), nil
+}
+
+// parseEncryptionRequestJSON 解析请求体里的 encryption 字段，未设置时返回 nil
+func parseEncryptionRequestJSON(raw json.RawMessage) (*encryptionRequest, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var req encryptionRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, fmt.Errorf("无效的 encryption 参数")
+	}
+
+	if req.Algorithm != sseAlgorithmSSEC {
+		return nil, fmt.Errorf("不支持的加密算法: %s", req.Algorithm)
+	}
+	if req.Key == "" {
+		return nil, fmt.Errorf("encryption.key 不能为空")
+	}
+
+	return &req, nil
+}
+
+// sseCFromHeader 从请求头读取客户提供的 SSE-C 密钥，未携带密钥时返回 nil
+func sseCFromHeader(c *fiber.Ctx) (encrypt.ServerSide, string, error) {
+	keyB64 := c.Get(sseCustomerKeyHeader)
+	if keyB64 == "" {
+		return nil, "", nil
+	}
+
+	if alg := c.Get(sseCustomerAlgorithmHeader); alg != "" && alg != sseAlgorithmSSEC {
+		return nil, "", fmt.Errorf("不支持的加密算法: %s", alg)
+	}
+
+	sse, fingerprint, err := sseCFromBase64Key(keyB64)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return sse, fingerprint, nil
+}
+
+// requireMatchingSSEC 校验本次请求携带的密钥指纹与上传任务记录的指纹一致，
+// 绝不记录密钥明文，只比较指纹
+func requireMatchingSSEC(wantFingerprint, gotFingerprint string) error {
+	if gotFingerprint == "" {
+		return fmt.Errorf("该上传已启用加密，缺少 %s 请求头", sseCustomerKeyHeader)
+	}
+	if gotFingerprint != wantFingerprint {
+		return fmt.Errorf("加密密钥不匹配")
+	}
+	return nil
+}
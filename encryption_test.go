@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+func TestRequireMatchingSSEC(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		got     string
+		wantErr bool
+	}{
+		{name: "指纹一致校验通过", want: "abc", got: "abc"},
+		{name: "未携带密钥报错", want: "abc", got: "", wantErr: true},
+		{name: "指纹不一致报错", want: "abc", got: "def", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := requireMatchingSSEC(tt.want, tt.got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("requireMatchingSSEC(%q, %q) error = %v, wantErr %v", tt.want, tt.got, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFingerprintSSECKeyIsStableAndDistinct(t *testing.T) {
+	keyA := []byte("0123456789abcdef0123456789abcdef")
+	keyB := []byte("fedcba9876543210fedcba9876543210")
+
+	if fingerprintSSECKey(keyA) != fingerprintSSECKey(keyA) {
+		t.Fatal("fingerprintSSECKey should be deterministic for the same key")
+	}
+	if fingerprintSSECKey(keyA) == fingerprintSSECKey(keyB) {
+		t.Fatal("fingerprintSSECKey should differ for different keys")
+	}
+}
+
+func TestSseCFromBase64Key(t *testing.T) {
+	rawKey := []byte("01234567890123456789012345678901")
+	validB64 := base64.StdEncoding.EncodeToString(rawKey)
+
+	t.Run("合法密钥返回匹配指纹", func(t *testing.T) {
+		sse, fingerprint, err := sseCFromBase64Key(validB64)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if sse == nil {
+			t.Fatal("expected non-nil ServerSide")
+		}
+		if fingerprint != fingerprintSSECKey(rawKey) {
+			t.Fatalf("fingerprint mismatch: got %q", fingerprint)
+		}
+	})
+
+	t.Run("非法 base64 编码报错", func(t *testing.T) {
+		if _, _, err := sseCFromBase64Key("not-valid-base64!!"); err == nil {
+			t.Fatal("expected error for invalid base64")
+		}
+	})
+
+	t.Run("密钥长度不是32字节报错", func(t *testing.T) {
+		shortKey := base64.StdEncoding.EncodeToString([]byte("too-short"))
+		if _, _, err := sseCFromBase64Key(shortKey); err == nil {
+			t.Fatal("expected error for wrong-length key")
+		}
+	})
+}
+
+func TestParseEncryptionRequestJSON(t *testing.T) {
+	t.Run("空字段返回 nil", func(t *testing.T) {
+		req, err := parseEncryptionRequestJSON(nil)
+		if err != nil || req != nil {
+			t.Fatalf("expected nil, nil; got %v, %v", req, err)
+		}
+	})
+
+	t.Run("缺少密钥报错", func(t *testing.T) {
+		raw := json.RawMessage(`{"algorithm":"SSE-C","key":""}`)
+		if _, err := parseEncryptionRequestJSON(raw); err == nil {
+			t.Fatal("expected error for empty key")
+		}
+	})
+
+	t.Run("不支持的算法报错", func(t *testing.T) {
+		raw := json.RawMessage(`{"algorithm":"AES256","key":"x"}`)
+		if _, err := parseEncryptionRequestJSON(raw); err == nil {
+			t.Fatal("expected error for unsupported algorithm")
+		}
+	})
+
+	t.Run("合法请求解析成功", func(t *testing.T) {
+		raw := json.RawMessage(`{"algorithm":"SSE-C","key":"abc"}`)
+		req, err := parseEncryptionRequestJSON(raw)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if req == nil || req.Key != "abc" {
+			t.Fatalf("unexpected result: %v", req)
+		}
+	})
+}
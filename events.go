@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/minio/minio-go/v7/pkg/notification"
+)
+
+// 推送给前端的事件类型
+const (
+	eventChunkReceived = "chunk.received"
+	eventChunkRemoved  = "chunk.removed"
+	eventFileUploaded  = "file.uploaded"
+	eventFileDeleted   = "file.deleted"
+)
+
+// FileEvent 是从 MinIO 桶通知翻译而来、推送给 SSE/WebSocket 客户端的事件
+type FileEvent struct {
+	Type       string    `json:"type"`
+	ObjectName string    `json:"objectName"`
+	UploadID   string    `json:"uploadId,omitempty"`
+	ChunkIndex *int      `json:"chunkIndex,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// eventBus 是进程内的发布-订阅中心，把桶通知事件广播给所有已连接的前端
+type eventBus struct {
+	mu   sync.RWMutex
+	subs map[chan FileEvent]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[chan FileEvent]struct{})}
+}
+
+func (b *eventBus) subscribe() chan FileEvent {
+	ch := make(chan FileEvent, 16)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *eventBus) unsubscribe(ch chan FileEvent) {
+	b.mu.Lock()
+	delete(b.subs, ch)
+	b.mu.Unlock()
+	close(ch)
+}
+
+func (b *eventBus) publish(evt FileEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- evt:
+		default:
+			// 订阅者消费不及时就丢弃这条事件，不能让慢客户端拖慢发布者
+			log.Printf("事件通道已满，丢弃事件: %s %s", evt.Type, evt.ObjectName)
+		}
+	}
+}
+
+var events = newEventBus()
+
+// matchesUploadFilter 判断事件是否属于客户端通过 uploadId 订阅的那次上传
+func (e FileEvent) matchesUploadFilter(uploadID string) bool {
+	return uploadID == "" || e.UploadID == uploadID
+}
+
+// objectUploadIndex 记录对象名到上传任务 ID 的映射，使合并完成后才到达的桶通知
+// 也能带上 uploadId；条目按创建时间淘汰，与上传 janitor 共用同一个扫描节奏
+var (
+	objectUploadIndex = make(map[string]struct {
+		uploadID  string
+		createdAt time.Time
+	})
+	objectUploadIndexMu sync.RWMutex
+)
+
+func registerObjectUploadIndex(objectName, uploadID string) {
+	objectUploadIndexMu.Lock()
+	defer objectUploadIndexMu.Unlock()
+	objectUploadIndex[objectName] = struct {
+		uploadID  string
+		createdAt time.Time
+	}{uploadID: uploadID, createdAt: time.Now()}
+}
+
+func lookupUploadID(objectName string) string {
+	objectUploadIndexMu.RLock()
+	defer objectUploadIndexMu.RUnlock()
+	return objectUploadIndex[objectName].uploadID
+}
+
+// pruneObjectUploadIndex 清理早于 olderThan 创建的索引条目
+func pruneObjectUploadIndex(olderThan time.Time) {
+	objectUploadIndexMu.Lock()
+	defer objectUploadIndexMu.Unlock()
+
+	for k, v := range objectUploadIndex {
+		if v.createdAt.Before(olderThan) {
+			delete(objectUploadIndex, k)
+		}
+	}
+}
+
+// chunkPartPattern 匹配分片对象名的 ".partN" 后缀
+var chunkPartPattern = regexp.MustCompile(`\.part(\d+)$`)
+
+// classifyObjectEvent 把桶通知里的原始对象 key 和事件名翻译成应用语义的事件，
+// 上传清单对象（.uploads/ 前缀）是内部记账用途，不对外暴露
+func classifyObjectEvent(key, eventName string) (eventType, objectName string, chunkIndex *int, ok bool) {
+	if strings.HasPrefix(key, uploadManifestPrefix) {
+		return "", "", nil, false
+	}
+
+	objectName = key
+	if m := chunkPartPattern.FindStringSubmatch(key); m != nil {
+		objectName = key[:len(key)-len(m[0])]
+		idx, err := strconv.Atoi(m[1])
+		if err == nil {
+			chunkIndex = &idx
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(eventName, "s3:ObjectCreated:"):
+		if chunkIndex != nil {
+			eventType = eventChunkReceived
+		} else {
+			eventType = eventFileUploaded
+		}
+	case strings.HasPrefix(eventName, "s3:ObjectRemoved:"):
+		if chunkIndex != nil {
+			eventType = eventChunkRemoved
+		} else {
+			eventType = eventFileDeleted
+		}
+	default:
+		return "", "", nil, false
+	}
+
+	return eventType, objectName, chunkIndex, true
+}
+
+// registerBucketNotification 尽力为桶注册一个通知配置。标准 MinIO 部署里
+// ListenBucketNotification 的长轮询本身不要求预先配置通知目标就能收到事件，
+// 这里注册失败只记录日志，不影响监听器启动
+func registerBucketNotification(ctx context.Context, bucketName string) {
+	arn := notification.NewArn("minio", "sqs", config.MinioEndpoint, "1", "webhook")
+	queueConfig := notification.NewConfig(arn)
+	queueConfig.AddEvents(notification.ObjectCreatedAll, notification.ObjectRemovedAll)
+
+	cfg := notification.Configuration{}
+	cfg.AddQueue(queueConfig)
+
+	if err := minioClient.SetBucketNotification(ctx, bucketName, cfg); err != nil {
+		log.Printf("注册桶通知配置失败（不影响事件监听器启动）: %v", err)
+	}
+}
+
+// startBucketNotificationListener 长期监听桶通知并转换为应用事件广播，
+// 连接断开后按指数退避重连，避免 MinIO 重启或网络抖动导致监听器彻底失效
+func startBucketNotificationListener(ctx context.Context, bucketName string) {
+	registerBucketNotification(ctx, bucketName)
+
+	go func() {
+		backoff := time.Second
+		const maxBackoff = 30 * time.Second
+
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+
+			listenCtx, cancel := context.WithCancel(ctx)
+			notifCh := minioClient.ListenBucketNotification(listenCtx, bucketName, "", "", []string{
+				string(notification.ObjectCreatedAll),
+				string(notification.ObjectRemovedAll),
+			})
+
+			for info := range notifCh {
+				if info.Err != nil {
+					log.Printf("桶通知监听出错: %v", info.Err)
+					break
+				}
+
+				backoff = time.Second
+
+				for _, record := range info.Records {
+					key, err := url.QueryUnescape(record.S3.Object.Key)
+					if err != nil {
+						key = record.S3.Object.Key
+					}
+
+					eventType, objectName, chunkIndex, ok := classifyObjectEvent(key, record.EventName)
+					if !ok {
+						continue
+					}
+
+					events.publish(FileEvent{
+						Type:       eventType,
+						ObjectName: objectName,
+						UploadID:   lookupUploadID(objectName),
+						ChunkIndex: chunkIndex,
+						Size:       record.S3.Object.Size,
+						Time:       time.Now(),
+					})
+				}
+			}
+			cancel()
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			log.Printf("桶通知监听连接已断开，%s 后重连", backoff)
+			time.Sleep(backoff)
+
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+	}()
+}
+
+// streamEvents 是 SSE 端点，按 uploadId 过滤后把事件以 text/event-stream 推送给客户端
+func streamEvents(c *fiber.Ctx) error {
+	uploadID := c.Query("uploadId")
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+
+	ch := events.subscribe()
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer events.unsubscribe(ch)
+
+		// fasthttp 的 RequestCtx.Done() 只在服务整体关闭时才会触发，并不能
+		// 感知单个连接断开；给连接加一个心跳节拍，idle 时也会定期尝试写入，
+		// 断开的连接会在这次写入/flush 失败，从而被及时回收，而不是永远
+		// 阻塞在 channel 接收上直到下一次真实事件到来
+		heartbeat := time.NewTicker(15 * time.Second)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				if err := w.Flush(); err != nil {
+					return
+				}
+			case evt, ok := <-ch:
+				if !ok {
+					return
+				}
+				if !evt.matchesUploadFilter(uploadID) {
+					continue
+				}
+
+				data, err := json.Marshal(evt)
+				if err != nil {
+					continue
+				}
+
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.Type, data); err != nil {
+					return
+				}
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	})
+
+	return nil
+}
+
+// streamEventsWS 是 WebSocket 端点，功能与 streamEvents 相同，供更适合双向通信的客户端使用
+func streamEventsWS(c *websocket.Conn) {
+	uploadID := c.Query("uploadId")
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	// 与 streamEvents 同样的问题：空闲连接断开后，在下一次真实事件到来前
+	// 不会触碰这个连接，订阅会一直挂着。定期写入一个 ping 帧当作心跳，
+	// 断开的连接会在这次写入失败，从而被及时回收
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-heartbeat.C:
+			if err := c.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case evt, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !evt.matchesUploadFilter(uploadID) {
+				continue
+			}
+
+			if err := c.WriteJSON(evt); err != nil {
+				return
+			}
+		}
+	}
+}
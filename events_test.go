@@ -0,0 +1,88 @@
+package main
+
+import "testing"
+
+func TestClassifyObjectEvent(t *testing.T) {
+	tests := []struct {
+		name           string
+		key            string
+		eventName      string
+		wantOK         bool
+		wantType       string
+		wantObjectName string
+		wantChunkIndex *int
+	}{
+		{
+			name:           "整文件创建事件",
+			key:            "1700000000_file.txt",
+			eventName:      "s3:ObjectCreated:Put",
+			wantOK:         true,
+			wantType:       eventFileUploaded,
+			wantObjectName: "1700000000_file.txt",
+		},
+		{
+			name:           "整文件删除事件",
+			key:            "1700000000_file.txt",
+			eventName:      "s3:ObjectRemoved:Delete",
+			wantOK:         true,
+			wantType:       eventFileDeleted,
+			wantObjectName: "1700000000_file.txt",
+		},
+		{
+			name:           "分片创建事件带分片序号",
+			key:            "1700000000_file.txt.part2",
+			eventName:      "s3:ObjectCreated:Put",
+			wantOK:         true,
+			wantType:       eventChunkReceived,
+			wantObjectName: "1700000000_file.txt",
+			wantChunkIndex: intPtr(2),
+		},
+		{
+			name:           "分片删除事件带分片序号",
+			key:            "1700000000_file.txt.part0",
+			eventName:      "s3:ObjectRemoved:Delete",
+			wantOK:         true,
+			wantType:       eventChunkRemoved,
+			wantObjectName: "1700000000_file.txt",
+			wantChunkIndex: intPtr(0),
+		},
+		{
+			name:      "上传清单对象不对外暴露",
+			key:       uploadManifestPrefix + "some-upload-id.json",
+			eventName: "s3:ObjectCreated:Put",
+			wantOK:    false,
+		},
+		{
+			name:      "不认识的事件类型被忽略",
+			key:       "1700000000_file.txt",
+			eventName: "s3:ObjectAccessed:Get",
+			wantOK:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eventType, objectName, chunkIndex, ok := classifyObjectEvent(tt.key, tt.eventName)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !tt.wantOK {
+				return
+			}
+			if eventType != tt.wantType {
+				t.Fatalf("eventType = %q, want %q", eventType, tt.wantType)
+			}
+			if objectName != tt.wantObjectName {
+				t.Fatalf("objectName = %q, want %q", objectName, tt.wantObjectName)
+			}
+			if (chunkIndex == nil) != (tt.wantChunkIndex == nil) {
+				t.Fatalf("chunkIndex = %v, want %v", chunkIndex, tt.wantChunkIndex)
+			}
+			if chunkIndex != nil && *chunkIndex != *tt.wantChunkIndex {
+				t.Fatalf("chunkIndex = %d, want %d", *chunkIndex, *tt.wantChunkIndex)
+			}
+		})
+	}
+}
+
+func intPtr(i int) *int { return &i }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+)
+
+// expiresAtTagKey 是写在对象上的标签键，值为过期时间的 Unix 秒数
+const expiresAtTagKey = "expires-at"
+
+// encryptedTagKey 标记对象是否以 SSE-C 加密落盘，值固定为 "true"；
+// 下载路由据此决定走预签名重定向还是服务端代理流式读取（见 getFile）
+const encryptedTagKey = "encrypted"
+
+// parseExpiresInString 解析表单/查询参数里的 expiresIn，支持纯数字秒数或 RFC3339 时间戳
+func parseExpiresInString(raw string, now time.Time) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+
+	if seconds, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, fmt.Errorf("expiresIn 不能为负数")
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return 0, fmt.Errorf("无效的 expiresIn: %v", err)
+	}
+
+	ttl := t.Sub(now)
+	if ttl < 0 {
+		return 0, fmt.Errorf("expiresIn 不能早于当前时间")
+	}
+
+	return ttl, nil
+}
+
+// parseExpiresInJSON 解析 JSON 请求体里的 expiresIn 字段，同样兼容数字秒数和 RFC3339 时间戳
+func parseExpiresInJSON(raw json.RawMessage, now time.Time) (time.Duration, error) {
+	if len(raw) == 0 {
+		return 0, nil
+	}
+
+	var asNumber float64
+	if err := json.Unmarshal(raw, &asNumber); err == nil {
+		if asNumber < 0 {
+			return 0, fmt.Errorf("expiresIn 不能为负数")
+		}
+		return time.Duration(asNumber) * time.Second, nil
+	}
+
+	var asString string
+	if err := json.Unmarshal(raw, &asString); err == nil {
+		return parseExpiresInString(asString, now)
+	}
+
+	return 0, fmt.Errorf("无效的 expiresIn 格式")
+}
+
+// clampObjectTTL 将请求的存活时间截断到服务端允许的最大值，防止调用方永久占用存储
+func clampObjectTTL(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return 0
+	}
+	if config.MaxObjectTTL > 0 && ttl > config.MaxObjectTTL {
+		return config.MaxObjectTTL
+	}
+	return ttl
+}
+
+// setObjectTags 把到期时间和加密标记写入对象标签；ttl <= 0 表示不设置过期。
+// PutObjectTagging 会整体替换对象的标签集，所以两个标记必须合并成一次调用写入，
+// 不能像两次独立的 setObjectExpiry 调用那样互相覆盖
+func setObjectTags(ctx context.Context, objectName string, ttl time.Duration, encrypted bool) error {
+	tagMap := make(map[string]string, 2)
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		tagMap[expiresAtTagKey] = strconv.FormatInt(expiresAt.Unix(), 10)
+	}
+	if encrypted {
+		tagMap[encryptedTagKey] = "true"
+	}
+	if len(tagMap) == 0 {
+		return nil
+	}
+
+	objectTags, err := tags.NewTags(tagMap, true)
+	if err != nil {
+		return fmt.Errorf("构建对象标签失败: %v", err)
+	}
+
+	return minioClient.PutObjectTagging(ctx, config.BucketName, objectName, objectTags, minio.PutObjectTaggingOptions{})
+}
+
+// getObjectTags 读取对象的过期时间和加密标记，一次 GetObjectTagging 调用拿全两者，
+// 供 listFiles 和下载路由共用，避免每个对象各打一次标签查询
+func getObjectTags(ctx context.Context, objectName string) (expiresAt *time.Time, encrypted bool, err error) {
+	objectTags, err := minioClient.GetObjectTagging(ctx, config.BucketName, objectName, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		return nil, false, err
+	}
+
+	tagMap := objectTags.ToMap()
+
+	if raw, ok := tagMap[expiresAtTagKey]; ok && raw != "" {
+		unixSeconds, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("解析过期标签失败: %v", err)
+		}
+		t := time.Unix(unixSeconds, 0)
+		expiresAt = &t
+	}
+
+	encrypted = tagMap[encryptedTagKey] == "true"
+	return expiresAt, encrypted, nil
+}
+
+// getObjectExpiry 读取对象的过期标签，没有设置过期时间则返回 nil
+func getObjectExpiry(ctx context.Context, objectName string) (*time.Time, error) {
+	expiresAt, _, err := getObjectTags(ctx, objectName)
+	return expiresAt, err
+}
+
+// isObjectEncrypted 读取对象的加密标签，判断该对象是否以 SSE-C 落盘——
+// 下载路由靠它在"预签名重定向"和"服务端代理流式读取"之间选择
+func isObjectEncrypted(ctx context.Context, objectName string) (bool, error) {
+	_, encrypted, err := getObjectTags(ctx, objectName)
+	return encrypted, err
+}
+
+// startExpirySweeper 周期性扫描桶内对象，删除已过期的文件，
+// 真正兑现"临时存储"——过期时间精确到每个对象自己的 TTL，而非按天粒度的桶生命周期规则
+func startExpirySweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for range ticker.C {
+			sweepExpiredObjects()
+		}
+	}()
+}
+
+func sweepExpiredObjects() {
+	ctx := context.Background()
+	now := time.Now()
+
+	objectCh := minioClient.ListObjects(ctx, config.BucketName, minio.ListObjectsOptions{
+		Recursive: true,
+	})
+
+	for object := range objectCh {
+		if object.Err != nil {
+			log.Printf("扫描过期对象失败: %v", object.Err)
+			continue
+		}
+
+		// 分片和上传清单对象由各自的清理逻辑负责，这里只关心最终交付的文件
+		if strings.Contains(object.Key, ".part") || strings.HasPrefix(object.Key, uploadManifestPrefix) {
+			continue
+		}
+
+		expiresAt, err := getObjectExpiry(ctx, object.Key)
+		if err != nil {
+			log.Printf("读取对象过期标签失败: %s, %v", object.Key, err)
+			continue
+		}
+		if expiresAt == nil || expiresAt.After(now) {
+			continue
+		}
+
+		if err := minioClient.RemoveObject(ctx, config.BucketName, object.Key, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("删除过期对象失败: %s, %v", object.Key, err)
+			continue
+		}
+
+		log.Printf("已删除过期对象: %s（过期时间 %s）", object.Key, expiresAt)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseExpiresInString(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		raw     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "空字符串表示不设置过期", raw: "", want: 0},
+		{name: "纯数字秒数", raw: "3600", want: time.Hour},
+		{name: "负数秒数报错", raw: "-1", wantErr: true},
+		{name: "未来的 RFC3339 时间戳", raw: now.Add(30 * time.Minute).Format(time.RFC3339), want: 30 * time.Minute},
+		{name: "过去的 RFC3339 时间戳报错", raw: now.Add(-time.Minute).Format(time.RFC3339), wantErr: true},
+		{name: "既不是数字也不是合法时间戳", raw: "not-a-time", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExpiresInString(tt.raw, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExpiresInString(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Fatalf("parseExpiresInString(%q) = %v, want %v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExpiresInJSON(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		raw     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "空字段表示不设置过期", raw: "", want: 0},
+		{name: "JSON 数字秒数", raw: "1800", want: 30 * time.Minute},
+		{name: "负数秒数报错", raw: "-5", wantErr: true},
+		{name: "JSON 字符串里的纯数字秒数", raw: `"60"`, want: time.Minute},
+		{name: "JSON 字符串里的 RFC3339 时间戳", raw: `"` + now.Add(time.Hour).Format(time.RFC3339) + `"`, want: time.Hour},
+		{name: "既不是数字也不是字符串", raw: "true", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw json.RawMessage
+			if tt.raw != "" {
+				raw = json.RawMessage(tt.raw)
+			}
+
+			got, err := parseExpiresInJSON(raw, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExpiresInJSON(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Fatalf("parseExpiresInJSON(%q) = %v, want %v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClampObjectTTL(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	config = &Config{MaxObjectTTL: time.Hour}
+
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{name: "零值原样返回", ttl: 0, want: 0},
+		{name: "负值截断为零", ttl: -time.Minute, want: 0},
+		{name: "未超出上限原样返回", ttl: 30 * time.Minute, want: 30 * time.Minute},
+		{name: "超出上限截断到上限", ttl: 2 * time.Hour, want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clampObjectTTL(tt.ttl); got != tt.want {
+				t.Fatalf("clampObjectTTL(%v) = %v, want %v", tt.ttl, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("MaxObjectTTL 为零表示不限制", func(t *testing.T) {
+		config = &Config{MaxObjectTTL: 0}
+		if got := clampObjectTTL(365 * 24 * time.Hour); got != 365*24*time.Hour {
+			t.Fatalf("clampObjectTTL with no max = %v, want unchanged", got)
+		}
+	})
+}
@@ -1,11 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
-	"io"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -13,16 +13,19 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 )
 
 // MultipartUploadInfo 存储分片上传信息
 type MultipartUploadInfo struct {
+	UploadID       string
 	FileName       string
 	ObjectName     string
 	FileSize       int64
@@ -30,18 +33,39 @@ type MultipartUploadInfo struct {
 	TotalChunks    int
 	UploadedChunks map[int]bool
 	CreatedAt      time.Time
-	mu             sync.RWMutex
+	// ViaPresign 标记分片是否由浏览器通过预签名 URL 直传，
+	// 此时服务端不会经手分片数据，完成上传时需改为直接校验分片是否存在
+	ViaPresign bool
+	// ExpiresIn 是合并完成后最终对象的存活时长，0 表示不过期
+	ExpiresIn time.Duration
+	// Encrypted 标记该上传是否启用了客户提供密钥（SSE-C）加密
+	Encrypted bool
+	// SSECKeyMD5 是加密密钥的指纹（而非密钥本身），用于校验后续分片/完成/下载
+	// 请求携带的密钥是否与初始化时一致
+	SSECKeyMD5 string
+	mu         sync.RWMutex `json:"-"`
 }
 
 // Config 应用配置
 type Config struct {
-	Port           string
-	MinioEndpoint  string
-	MinioPort      int
-	MinioUseSSL    bool
-	MinioAccessKey string
-	MinioSecretKey string
-	BucketName     string
+	Port                 string
+	MinioEndpoint        string
+	MinioPort            int
+	MinioUseSSL          bool
+	MinioAccessKey       string
+	MinioSecretKey       string
+	BucketName           string
+	PresignExpiryDefault time.Duration
+	PresignExpiryMax     time.Duration
+	// UploadStoreBackend 为 "bolt"（默认，本地文件）或 "minio"（清单对象存于桶内）
+	UploadStoreBackend string
+	UploadDBPath       string
+	UploadTTL          time.Duration
+	JanitorInterval    time.Duration
+	// MaxObjectTTL 是客户端可为对象请求的最长存活时间，超出会被截断
+	MaxObjectTTL time.Duration
+	// ExpirySweepInterval 是扫描并清理已过期对象的间隔
+	ExpirySweepInterval time.Duration
 }
 
 var (
@@ -49,8 +73,31 @@ var (
 	config           *Config
 	multipartUploads = make(map[string]*MultipartUploadInfo)
 	uploadsMutex     sync.RWMutex
+	uploadStore      UploadStore
 )
 
+// 预签名 URL 的默认/最大有效期
+const (
+	defaultPresignExpiry = 15 * time.Minute
+	maxPresignExpiry     = 24 * time.Hour
+)
+
+// 在途上传的默认过期时间和清理任务扫描间隔
+const (
+	defaultUploadTTL       = 24 * time.Hour
+	defaultJanitorInterval = 30 * time.Minute
+)
+
+// 已完成对象的默认最长存活时间和过期扫描间隔
+const (
+	defaultMaxObjectTTL        = 7 * 24 * time.Hour
+	defaultExpirySweepInterval = 5 * time.Minute
+)
+
+// minComposePartSize 是 MinIO ComposeObject 对非最后一个源分片的大小下限，
+// 小于这个值会被拒绝合并（最后一个分片不受此限制）
+const minComposePartSize = 5 * 1024 * 1024
+
 // 初始化配置
 func initConfig() error {
 	// 加载 .env 文件
@@ -92,14 +139,86 @@ func initConfig() error {
 		return fmt.Errorf("BUCKET_NAME 环境变量未设置")
 	}
 
+	presignExpiryDefault := defaultPresignExpiry
+	if v := os.Getenv("PRESIGN_EXPIRY_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("无效的 PRESIGN_EXPIRY_SECONDS: %v", err)
+		}
+		presignExpiryDefault = time.Duration(seconds) * time.Second
+	}
+
+	presignExpiryMax := maxPresignExpiry
+	if v := os.Getenv("PRESIGN_EXPIRY_MAX_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("无效的 PRESIGN_EXPIRY_MAX_SECONDS: %v", err)
+		}
+		presignExpiryMax = time.Duration(seconds) * time.Second
+	}
+
+	uploadStoreBackend := os.Getenv("UPLOAD_STORE_BACKEND")
+	if uploadStoreBackend == "" {
+		uploadStoreBackend = "bolt"
+	}
+
+	uploadDBPath := os.Getenv("UPLOAD_DB_PATH")
+	if uploadDBPath == "" {
+		uploadDBPath = "uploads.db"
+	}
+
+	uploadTTL := defaultUploadTTL
+	if v := os.Getenv("UPLOAD_TTL_MINUTES"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("无效的 UPLOAD_TTL_MINUTES: %v", err)
+		}
+		uploadTTL = time.Duration(minutes) * time.Minute
+	}
+
+	janitorInterval := defaultJanitorInterval
+	if v := os.Getenv("JANITOR_INTERVAL_MINUTES"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("无效的 JANITOR_INTERVAL_MINUTES: %v", err)
+		}
+		janitorInterval = time.Duration(minutes) * time.Minute
+	}
+
+	maxObjectTTL := defaultMaxObjectTTL
+	if v := os.Getenv("MAX_OBJECT_TTL_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("无效的 MAX_OBJECT_TTL_SECONDS: %v", err)
+		}
+		maxObjectTTL = time.Duration(seconds) * time.Second
+	}
+
+	expirySweepInterval := defaultExpirySweepInterval
+	if v := os.Getenv("EXPIRY_SWEEP_INTERVAL_MINUTES"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("无效的 EXPIRY_SWEEP_INTERVAL_MINUTES: %v", err)
+		}
+		expirySweepInterval = time.Duration(minutes) * time.Minute
+	}
+
 	config = &Config{
-		Port:           port,
-		MinioEndpoint:  minioEndpoint,
-		MinioPort:      minioPort,
-		MinioUseSSL:    minioUseSSL,
-		MinioAccessKey: minioAccessKey,
-		MinioSecretKey: minioSecretKey,
-		BucketName:     bucketName,
+		Port:                 port,
+		MinioEndpoint:        minioEndpoint,
+		MinioPort:            minioPort,
+		MinioUseSSL:          minioUseSSL,
+		MinioAccessKey:       minioAccessKey,
+		MinioSecretKey:       minioSecretKey,
+		BucketName:           bucketName,
+		PresignExpiryDefault: presignExpiryDefault,
+		PresignExpiryMax:     presignExpiryMax,
+		UploadStoreBackend:   uploadStoreBackend,
+		UploadDBPath:         uploadDBPath,
+		UploadTTL:            uploadTTL,
+		JanitorInterval:      janitorInterval,
+		MaxObjectTTL:         maxObjectTTL,
+		ExpirySweepInterval:  expirySweepInterval,
 	}
 
 	fmt.Printf("配置: %+v\n", config)
@@ -168,9 +287,11 @@ func initializeBucket() error {
 // 初始化分片上传
 func initMultipartUpload(c *fiber.Ctx) error {
 	var request struct {
-		FileName  string `json:"fileName"`
-		FileSize  int64  `json:"fileSize"`
-		ChunkSize int64  `json:"chunkSize"`
+		FileName   string          `json:"fileName"`
+		FileSize   int64           `json:"fileSize"`
+		ChunkSize  int64           `json:"chunkSize"`
+		ExpiresIn  json.RawMessage `json:"expiresIn"`
+		Encryption json.RawMessage `json:"encryption"`
 	}
 
 	if err := c.BodyParser(&request); err != nil {
@@ -185,11 +306,34 @@ func initMultipartUpload(c *fiber.Ctx) error {
 		})
 	}
 
+	totalChunks := int((request.FileSize + request.ChunkSize - 1) / request.ChunkSize)
+	// ComposeObject 要求除最后一个分片外，每个源分片不得小于 5MiB，
+	// 否则合并时会被 MinIO 拒绝；单分片走 CopyObject，不受此限制
+	if totalChunks > 1 && request.ChunkSize < minComposePartSize {
+		return c.Status(400).JSON(fiber.Map{
+			"error": fmt.Sprintf("chunkSize 过小：多分片上传时每个分片至少需要 %d 字节（最后一个分片除外）", minComposePartSize),
+		})
+	}
+
+	expiresIn, err := parseExpiresInJSON(request.ExpiresIn, time.Now())
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	encryptionReq, err := parseEncryptionRequestJSON(request.Encryption)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
 	uploadID := uuid.New().String()
 	objectName := fmt.Sprintf("%d_%s", time.Now().Unix(), request.FileName)
-	totalChunks := int((request.FileSize + request.ChunkSize - 1) / request.ChunkSize)
 
 	uploadInfo := &MultipartUploadInfo{
+		UploadID:       uploadID,
 		FileName:       request.FileName,
 		ObjectName:     objectName,
 		FileSize:       request.FileSize,
@@ -197,12 +341,33 @@ func initMultipartUpload(c *fiber.Ctx) error {
 		TotalChunks:    totalChunks,
 		UploadedChunks: make(map[int]bool),
 		CreatedAt:      time.Now(),
+		ExpiresIn:      clampObjectTTL(expiresIn),
+	}
+
+	if encryptionReq != nil {
+		_, fingerprint, err := sseCFromBase64Key(encryptionReq.Key)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		uploadInfo.Encrypted = true
+		uploadInfo.SSECKeyMD5 = fingerprint
+	}
+
+	if err := uploadStore.Create(uploadInfo); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "持久化上传状态失败",
+		})
 	}
 
 	uploadsMutex.Lock()
 	multipartUploads[uploadID] = uploadInfo
 	uploadsMutex.Unlock()
 
+	// 记录对象名到 uploadId 的映射，供桶通知事件按 uploadId 过滤使用
+	registerObjectUploadIndex(objectName, uploadID)
+
 	return c.JSON(fiber.Map{
 		"uploadId":    uploadID,
 		"objectName":  objectName,
@@ -255,9 +420,26 @@ func uploadChunk(c *fiber.Ctx) error {
 
 	chunkObjectName := fmt.Sprintf("%s.part%d", uploadInfo.ObjectName, chunkIndex)
 
+	var sse encrypt.ServerSide
+	if uploadInfo.Encrypted {
+		headerSSE, fingerprint, err := sseCFromHeader(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if err := requireMatchingSSEC(uploadInfo.SSECKeyMD5, fingerprint); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		sse = headerSSE
+	}
+
 	ctx := context.Background()
 	_, err = minioClient.PutObject(ctx, config.BucketName, chunkObjectName, fileContent, file.Size, minio.PutObjectOptions{
-		ContentType: "application/octet-stream",
+		ContentType:          "application/octet-stream",
+		ServerSideEncryption: sse,
 	})
 
 	if err != nil {
@@ -271,6 +453,29 @@ func uploadChunk(c *fiber.Ctx) error {
 	uploadedCount := len(uploadInfo.UploadedChunks)
 	uploadInfo.mu.Unlock()
 
+	if err := uploadStore.MarkChunk(uploadID, chunkIndex); err != nil {
+		log.Printf("持久化分片状态失败: %s, %v", uploadID, err)
+	}
+
+	// 允许客户端在任意一次分片请求中设置/更新完成后最终文件的存活时间
+	if raw := c.FormValue("expiresIn"); raw != "" {
+		expiresIn, err := parseExpiresInString(raw, time.Now())
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		ttl := clampObjectTTL(expiresIn)
+		uploadInfo.mu.Lock()
+		uploadInfo.ExpiresIn = ttl
+		uploadInfo.mu.Unlock()
+
+		if err := uploadStore.SetExpiry(uploadID, ttl); err != nil {
+			log.Printf("持久化过期时间失败: %s, %v", uploadID, err)
+		}
+	}
+
 	log.Printf("分片 %d 上传成功，对象名: %s", chunkIndex, chunkObjectName)
 
 	return c.JSON(fiber.Map{
@@ -314,51 +519,66 @@ func completeMultipartUpload(c *fiber.Ctx) error {
 	totalChunks := uploadInfo.TotalChunks
 	uploadInfo.mu.RUnlock()
 
-	if uploadedCount != totalChunks {
+	var sse encrypt.ServerSide
+	if uploadInfo.Encrypted {
+		headerSSE, fingerprint, err := sseCFromHeader(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if err := requireMatchingSSEC(uploadInfo.SSECKeyMD5, fingerprint); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		sse = headerSSE
+	}
+
+	ctx := context.Background()
+
+	if uploadInfo.ViaPresign {
+		// 分片由浏览器直接 PUT 到 MinIO，服务端没有经手分片数据，
+		// 无法通过 UploadedChunks 计数判断完成情况，改为逐个校验分片是否存在
+		for i := 0; i < totalChunks; i++ {
+			chunkObjectName := fmt.Sprintf("%s.part%d", uploadInfo.ObjectName, i)
+			if _, err := minioClient.StatObject(ctx, config.BucketName, chunkObjectName, minio.StatObjectOptions{}); err != nil {
+				return c.Status(400).JSON(fiber.Map{
+					"error": fmt.Sprintf("分片 %d 尚未上传", i),
+				})
+			}
+		}
+	} else if uploadedCount != totalChunks {
 		return c.Status(400).JSON(fiber.Map{
 			"error":          "还有分片未上传完成",
 			"uploadedChunks": uploadedCount,
 			"totalChunks":    totalChunks,
 		})
 	}
-
-	ctx := context.Background()
 	finalObject := uploadInfo.ObjectName
 
 	if totalChunks > 1 {
-		// 多个分片，需要合并
-		var chunks [][]byte
+		// 多个分片，使用 MinIO 服务端合并（ComposeObject），
+		// 分片数据不经过我们的进程，避免大文件 OOM 和双倍带宽消耗
+		srcs := make([]minio.CopySrcOptions, totalChunks)
 		for i := 0; i < totalChunks; i++ {
-			chunkObjectName := fmt.Sprintf("%s.part%d", uploadInfo.ObjectName, i)
-
-			obj, err := minioClient.GetObject(ctx, config.BucketName, chunkObjectName, minio.GetObjectOptions{})
-			if err != nil {
-				return c.Status(500).JSON(fiber.Map{
-					"error": fmt.Sprintf("获取分片 %d 失败", i),
-				})
-			}
-
-			chunkData, err := io.ReadAll(obj)
-			obj.Close()
-			if err != nil {
-				return c.Status(500).JSON(fiber.Map{
-					"error": fmt.Sprintf("读取分片 %d 失败", i),
-				})
+			srcs[i] = minio.CopySrcOptions{
+				Bucket:     config.BucketName,
+				Object:     fmt.Sprintf("%s.part%d", uploadInfo.ObjectName, i),
+				Encryption: sse,
 			}
-
-			chunks = append(chunks, chunkData)
 		}
 
-		// 合并所有分片
-		mergedData := bytes.Join(chunks, []byte{})
+		dstOpts := minio.CopyDestOptions{
+			Bucket:     config.BucketName,
+			Object:     finalObject,
+			Encryption: sse,
+		}
 
-		// 上传合并后的文件
-		_, err := minioClient.PutObject(ctx, config.BucketName, finalObject, bytes.NewReader(mergedData), int64(len(mergedData)), minio.PutObjectOptions{
-			ContentType: "application/octet-stream",
-		})
+		_, err := minioClient.ComposeObject(ctx, dstOpts, srcs...)
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{
-				"error": "上传合并文件失败",
+				"error": fmt.Sprintf("合并分片失败: %v", err),
 			})
 		}
 
@@ -375,13 +595,15 @@ func completeMultipartUpload(c *fiber.Ctx) error {
 		chunkObjectName := fmt.Sprintf("%s.part0", uploadInfo.ObjectName)
 
 		srcOpts := minio.CopySrcOptions{
-			Bucket: config.BucketName,
-			Object: chunkObjectName,
+			Bucket:     config.BucketName,
+			Object:     chunkObjectName,
+			Encryption: sse,
 		}
 
 		dstOpts := minio.CopyDestOptions{
-			Bucket: config.BucketName,
-			Object: finalObject,
+			Bucket:     config.BucketName,
+			Object:     finalObject,
+			Encryption: sse,
 		}
 
 		_, err := minioClient.CopyObject(ctx, dstOpts, srcOpts)
@@ -398,10 +620,23 @@ func completeMultipartUpload(c *fiber.Ctx) error {
 		}
 	}
 
-	// 生成下载URL
-	downloadURL := fmt.Sprintf("http://%s:%d/%s/%s", config.MinioEndpoint, config.MinioPort, config.BucketName, finalObject)
+	if err := setObjectTags(ctx, finalObject, uploadInfo.ExpiresIn, uploadInfo.Encrypted); err != nil {
+		log.Printf("设置对象标签失败: %s, %v", finalObject, err)
+	}
+
+	// 下载地址指向 /download 重定向端点，由它按需生成限时预签名 URL，避免在响应里
+	// 直接暴露内部 MinIO 地址；SSE-C 加密对象的预签名 URL 无法携带客户密钥请求头，
+	// 所以改为指向 getFile 的服务端流式代理端点
+	downloadURL := fmt.Sprintf("/api/files/%s/download", url.PathEscape(finalObject))
+	if uploadInfo.Encrypted {
+		downloadURL = fmt.Sprintf("/api/files/%s", url.PathEscape(finalObject))
+	}
 
 	// 清理上传信息
+	if err := uploadStore.Delete(request.UploadID); err != nil {
+		log.Printf("删除持久化上传记录失败: %s, %v", request.UploadID, err)
+	}
+
 	uploadsMutex.Lock()
 	delete(multipartUploads, request.UploadID)
 	uploadsMutex.Unlock()
@@ -415,6 +650,8 @@ func completeMultipartUpload(c *fiber.Ctx) error {
 }
 
 // 取消上传
+// 分片以独立对象形式存储（未使用原生 S3 分片上传），所以取消时
+// 直接删除已上传的 .partN 对象即可，无需调用 AbortMultipartUpload
 func cancelUpload(c *fiber.Ctx) error {
 	uploadID := c.Params("uploadId")
 
@@ -440,6 +677,10 @@ func cancelUpload(c *fiber.Ctx) error {
 	}
 
 	// 清理上传信息
+	if err := uploadStore.Delete(uploadID); err != nil {
+		log.Printf("删除持久化上传记录失败: %s, %v", uploadID, err)
+	}
+
 	uploadsMutex.Lock()
 	delete(multipartUploads, uploadID)
 	uploadsMutex.Unlock()
@@ -450,7 +691,9 @@ func cancelUpload(c *fiber.Ctx) error {
 	})
 }
 
-// 获取上传状态
+// 获取上传状态（一次性快照）
+// 实时进度应优先通过 GET /api/events（或 /api/events/ws）订阅推送，
+// 这个接口仅用于页面刚打开、还没收到任何推送事件时的初始状态查询
 func getUploadStatus(c *fiber.Ctx) error {
 	uploadID := c.Params("uploadId")
 
@@ -489,6 +732,13 @@ func uploadSingle(c *fiber.Ctx) error {
 		})
 	}
 
+	expiresIn, err := parseExpiresInString(c.FormValue("expiresIn"), time.Now())
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
 	fileContent, err := file.Open()
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
@@ -497,11 +747,19 @@ func uploadSingle(c *fiber.Ctx) error {
 	}
 	defer fileContent.Close()
 
+	sse, _, err := sseCFromHeader(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
 	objectName := fmt.Sprintf("%d_%s", time.Now().Unix(), file.Filename)
 
 	ctx := context.Background()
 	_, err = minioClient.PutObject(ctx, config.BucketName, objectName, fileContent, file.Size, minio.PutObjectOptions{
-		ContentType: "application/octet-stream",
+		ContentType:          "application/octet-stream",
+		ServerSideEncryption: sse,
 	})
 
 	if err != nil {
@@ -510,7 +768,15 @@ func uploadSingle(c *fiber.Ctx) error {
 		})
 	}
 
-	downloadURL := fmt.Sprintf("http://%s:%d/%s/%s", config.MinioEndpoint, config.MinioPort, config.BucketName, objectName)
+	encrypted := sse != nil
+	if err := setObjectTags(ctx, objectName, clampObjectTTL(expiresIn), encrypted); err != nil {
+		log.Printf("设置对象标签失败: %s, %v", objectName, err)
+	}
+
+	downloadURL := fmt.Sprintf("/api/files/%s/download", url.PathEscape(objectName))
+	if encrypted {
+		downloadURL = fmt.Sprintf("/api/files/%s", url.PathEscape(objectName))
+	}
 
 	return c.JSON(fiber.Map{
 		"success":     true,
@@ -539,18 +805,33 @@ func listFiles(c *fiber.Ctx) error {
 			})
 		}
 
-		// 跳过分片文件
-		if strings.Contains(object.Key, ".part") {
+		// 跳过分片文件和上传清单对象
+		if strings.Contains(object.Key, ".part") || strings.HasPrefix(object.Key, uploadManifestPrefix) {
 			continue
 		}
 
-		url := fmt.Sprintf("http://%s:%d/%s/%s", config.MinioEndpoint, config.MinioPort, config.BucketName, object.Key)
+		downloadURL := fmt.Sprintf("/api/files/%s/download", url.PathEscape(object.Key))
+
+		var expiresAt *time.Time
+		var remainingSeconds *int64
+		if t, encrypted, err := getObjectTags(ctx, object.Key); err == nil {
+			if encrypted {
+				downloadURL = fmt.Sprintf("/api/files/%s", url.PathEscape(object.Key))
+			}
+			if t != nil {
+				expiresAt = t
+				remaining := int64(time.Until(*t).Seconds())
+				remainingSeconds = &remaining
+			}
+		}
 
 		files = append(files, fiber.Map{
-			"name":         object.Key,
-			"size":         object.Size,
-			"lastModified": object.LastModified,
-			"url":          url,
+			"name":             object.Key,
+			"size":             object.Size,
+			"lastModified":     object.LastModified,
+			"url":              downloadURL,
+			"expiresAt":        expiresAt,
+			"remainingSeconds": remainingSeconds,
 		})
 	}
 
@@ -577,6 +858,353 @@ func deleteFile(c *fiber.Ctx) error {
 	})
 }
 
+// 延长/设置文件的过期时间
+func updateFileExpiry(c *fiber.Ctx) error {
+	objectName := c.Params("objectName")
+
+	var request struct {
+		ExpiresIn json.RawMessage `json:"expiresIn"`
+	}
+
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "解析请求体失败",
+		})
+	}
+
+	expiresIn, err := parseExpiresInJSON(request.ExpiresIn, time.Now())
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if expiresIn <= 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "缺少必要参数: expiresIn",
+		})
+	}
+
+	ttl := clampObjectTTL(expiresIn)
+	ctx := context.Background()
+
+	if _, err := minioClient.StatObject(ctx, config.BucketName, objectName, minio.StatObjectOptions{}); err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "文件不存在",
+		})
+	}
+
+	// PutObjectTagging 整体替换标签集，更新过期时间前要先读出已有的加密标记，
+	// 否则会把 SSE-C 对象的 encrypted 标签连带清空；读取失败时必须中止，
+	// 不能悄悄当作"未加密"继续，那样会永久丢失这个标记
+	_, encrypted, err := getObjectTags(ctx, objectName)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "读取对象标签失败",
+		})
+	}
+
+	if err := setObjectTags(ctx, objectName, ttl, encrypted); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "设置过期时间失败",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success":          true,
+		"objectName":       objectName,
+		"expiresAt":        time.Now().Add(ttl),
+		"remainingSeconds": int64(ttl.Seconds()),
+	})
+}
+
+// resolveExpiry 解析客户端传入的有效期（秒），未指定时使用默认值，
+// 超过服务端配置的上限时按上限截断
+func resolveExpiry(requestedSeconds int) (time.Duration, error) {
+	if requestedSeconds == 0 {
+		return config.PresignExpiryDefault, nil
+	}
+
+	if requestedSeconds < 0 {
+		return 0, fmt.Errorf("expiresIn 不能为负数")
+	}
+
+	expiry := time.Duration(requestedSeconds) * time.Second
+	if expiry > config.PresignExpiryMax {
+		expiry = config.PresignExpiryMax
+	}
+
+	return expiry, nil
+}
+
+// 生成预签名上传 URL（单文件或分片），浏览器可直接 PUT 到 MinIO，字节不经过服务端
+func presignUpload(c *fiber.Ctx) error {
+	var request struct {
+		FileName  string `json:"fileName"`
+		FileSize  int64  `json:"fileSize"`
+		ChunkSize int64  `json:"chunkSize"`
+		ExpiresIn int    `json:"expiresIn"`
+	}
+
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "解析请求体失败",
+		})
+	}
+
+	if request.FileName == "" || request.FileSize == 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "缺少必要参数: fileName, fileSize",
+		})
+	}
+
+	expiry, err := resolveExpiry(request.ExpiresIn)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	objectName := fmt.Sprintf("%d_%s", time.Now().Unix(), request.FileName)
+	ctx := context.Background()
+
+	if request.ChunkSize > 0 && request.FileSize > request.ChunkSize {
+		totalChunks := int((request.FileSize + request.ChunkSize - 1) / request.ChunkSize)
+		// 预签名分片同样在 /upload/complete 里走 ComposeObject 合并，
+		// 受 MinIO 的非末尾分片 5MiB 下限约束
+		if totalChunks > 1 && request.ChunkSize < minComposePartSize {
+			return c.Status(400).JSON(fiber.Map{
+				"error": fmt.Sprintf("chunkSize 过小：多分片上传时每个分片至少需要 %d 字节（最后一个分片除外）", minComposePartSize),
+			})
+		}
+
+		partURLs := make([]string, totalChunks)
+
+		for i := 0; i < totalChunks; i++ {
+			chunkObjectName := fmt.Sprintf("%s.part%d", objectName, i)
+			partURL, err := minioClient.PresignedPutObject(ctx, config.BucketName, chunkObjectName, expiry)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{
+					"error": fmt.Sprintf("生成分片 %d 预签名URL失败", i),
+				})
+			}
+			partURLs[i] = partURL.String()
+		}
+
+		uploadID := uuid.New().String()
+		uploadInfo := &MultipartUploadInfo{
+			UploadID:       uploadID,
+			FileName:       request.FileName,
+			ObjectName:     objectName,
+			FileSize:       request.FileSize,
+			ChunkSize:      request.ChunkSize,
+			TotalChunks:    totalChunks,
+			UploadedChunks: make(map[int]bool),
+			CreatedAt:      time.Now(),
+			ViaPresign:     true,
+		}
+
+		if err := uploadStore.Create(uploadInfo); err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "持久化上传状态失败",
+			})
+		}
+
+		uploadsMutex.Lock()
+		multipartUploads[uploadID] = uploadInfo
+		uploadsMutex.Unlock()
+
+		// 分片经预签名 URL 直传，服务端收不到数据，只能靠桶通知感知进度，
+		// 所以同样要登记 objectName -> uploadId 映射，事件才能按 uploadId 过滤
+		registerObjectUploadIndex(objectName, uploadID)
+
+		return c.JSON(fiber.Map{
+			"uploadId":    uploadID,
+			"objectName":  objectName,
+			"totalChunks": totalChunks,
+			"partUrls":    partURLs,
+			"expiresIn":   int(expiry.Seconds()),
+		})
+	}
+
+	uploadURL, err := minioClient.PresignedPutObject(ctx, config.BucketName, objectName, expiry)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "生成预签名URL失败",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"objectName": objectName,
+		"uploadUrl":  uploadURL.String(),
+		"expiresIn":  int(expiry.Seconds()),
+	})
+}
+
+// 生成 HTML 表单直传所需的预签名 POST 策略，大小/类型限制由 PostPolicy 约束，
+// 浏览器可直接 <form> POST 到 MinIO
+func presignUploadForm(c *fiber.Ctx) error {
+	var request struct {
+		FileName    string `json:"fileName"`
+		ContentType string `json:"contentType"`
+		MaxSize     int64  `json:"maxSize"`
+		ExpiresIn   int    `json:"expiresIn"`
+	}
+
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "解析请求体失败",
+		})
+	}
+
+	if request.FileName == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "缺少必要参数: fileName",
+		})
+	}
+
+	expiry, err := resolveExpiry(request.ExpiresIn)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	objectName := fmt.Sprintf("%d_%s", time.Now().Unix(), request.FileName)
+
+	policy := minio.NewPostPolicy()
+	if err := policy.SetBucket(config.BucketName); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "设置策略失败"})
+	}
+	if err := policy.SetKey(objectName); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "设置策略失败"})
+	}
+	if err := policy.SetExpires(time.Now().UTC().Add(expiry)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "设置策略失败"})
+	}
+	if request.ContentType != "" {
+		if err := policy.SetContentType(request.ContentType); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "设置策略失败"})
+		}
+	}
+	if request.MaxSize > 0 {
+		if err := policy.SetContentLengthRange(1, request.MaxSize); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "设置策略失败"})
+		}
+	}
+
+	ctx := context.Background()
+	postURL, formData, err := minioClient.PresignedPostPolicy(ctx, policy)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "生成表单上传策略失败",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"objectName": objectName,
+		"postUrl":    postURL.String(),
+		"formData":   formData,
+		"expiresIn":  int(expiry.Seconds()),
+	})
+}
+
+// 下载文件：302 跳转到限时预签名 GET URL，避免暴露内部 MinIO 地址、
+// 也无需把私有桶的对象经由服务端中转
+func downloadFile(c *fiber.Ctx) error {
+	objectName := c.Params("objectName")
+
+	expiresIn, err := strconv.Atoi(c.Query("expiresIn", "0"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "无效的 expiresIn",
+		})
+	}
+
+	expiry, err := resolveExpiry(expiresIn)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	reqParams := make(url.Values)
+	if fileName := c.Query("fileName"); fileName != "" {
+		reqParams.Set("response-content-disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	}
+
+	ctx := context.Background()
+	downloadURL, err := minioClient.PresignedGetObject(ctx, config.BucketName, objectName, expiry, reqParams)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "生成下载链接失败",
+		})
+	}
+
+	return c.Redirect(downloadURL.String(), fiber.StatusFound)
+}
+
+// 直接流式读取文件，供使用 SSE-C 加密的文件下载——预签名 URL 无法携带
+// 客户密钥请求头，只能由服务端代为 GetObject 并把解密后的字节流转发给客户端
+func getFile(c *fiber.Ctx) error {
+	objectName := c.Params("objectName")
+
+	sse, _, err := sseCFromHeader(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ctx := context.Background()
+	obj, err := minioClient.GetObject(ctx, config.BucketName, objectName, minio.GetObjectOptions{
+		ServerSideEncryption: sse,
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "读取文件失败",
+		})
+	}
+	defer obj.Close()
+
+	stat, err := obj.Stat()
+	if err != nil {
+		// 密钥缺失或不匹配时，MinIO 在读取时才会返回错误
+		return c.Status(400).JSON(fiber.Map{
+			"error": "文件不存在或加密密钥不正确",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/octet-stream")
+	return c.SendStream(obj, int(stat.Size))
+}
+
+// 列出所有在途上传，供前端实现"断点续传/恢复上传"的选择列表
+func listUploads(c *fiber.Ctx) error {
+	uploadsMutex.RLock()
+	defer uploadsMutex.RUnlock()
+
+	uploads := make([]fiber.Map, 0, len(multipartUploads))
+	for _, info := range multipartUploads {
+		info.mu.RLock()
+		uploadedCount := len(info.UploadedChunks)
+		info.mu.RUnlock()
+
+		uploads = append(uploads, fiber.Map{
+			"uploadId":       info.UploadID,
+			"fileName":       info.FileName,
+			"objectName":     info.ObjectName,
+			"fileSize":       info.FileSize,
+			"totalChunks":    info.TotalChunks,
+			"uploadedChunks": uploadedCount,
+			"createdAt":      info.CreatedAt,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"uploads": uploads,
+	})
+}
+
 func main() {
 	// 初始化配置
 	if err := initConfig(); err != nil {
@@ -593,6 +1221,26 @@ func main() {
 		log.Fatalf("初始化存储桶失败: %v", err)
 	}
 
+	// 初始化上传状态持久化存储，并恢复重启前的在途上传
+	store, err := newUploadStore(config, minioClient)
+	if err != nil {
+		log.Fatalf("初始化上传状态存储失败: %v", err)
+	}
+	uploadStore = store
+
+	if err := rehydrateUploads(uploadStore); err != nil {
+		log.Fatalf("恢复上传状态失败: %v", err)
+	}
+
+	// 启动后台清理任务，定期清理过期的孤儿上传和分片
+	startUploadJanitor(uploadStore, config.JanitorInterval, config.UploadTTL)
+
+	// 启动过期对象扫描器，兑现"临时存储"——定期删除已到期的最终文件
+	startExpirySweeper(config.ExpirySweepInterval)
+
+	// 启动桶通知监听器，把分片到达/文件上传完成/文件删除实时推送给 SSE/WebSocket 客户端
+	startBucketNotificationListener(context.Background(), config.BucketName)
+
 	// 创建 Fiber 应用
 	app := fiber.New(fiber.Config{
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
@@ -608,10 +1256,16 @@ func main() {
 	})
 
 	// 中间件配置
+	// SSE-C 客户密钥通过自定义请求头传递（见 encryption.go），跨域浏览器客户端
+	// 发起 preflight 时必须显式放行这两个头，否则请求头过不了 CORS 校验，
+	// 加密上传/下载在真实浏览器里就永远走不通
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
 		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders: "Origin,Content-Type,Accept,Authorization",
+		AllowHeaders: strings.Join([]string{
+			"Origin", "Content-Type", "Accept", "Authorization",
+			sseCustomerKeyHeader, sseCustomerAlgorithmHeader,
+		}, ","),
 	}))
 
 	// 静态文件服务 - 提供 index.html
@@ -628,13 +1282,31 @@ func main() {
 	api.Post("/upload/complete", completeMultipartUpload)
 	api.Delete("/upload/:uploadId", cancelUpload)
 	api.Get("/upload/:uploadId/status", getUploadStatus)
+	api.Get("/uploads", listUploads)
 
 	// 单文件上传
 	api.Post("/upload/single", uploadSingle)
 
+	// 预签名 URL，浏览器直传/直下，字节不经过服务端
+	api.Post("/upload/presign", presignUpload)
+	api.Post("/upload/presign-form", presignUploadForm)
+
 	// 文件管理
 	api.Get("/files", listFiles)
 	api.Delete("/files/:objectName", deleteFile)
+	api.Get("/files/:objectName/download", downloadFile)
+	api.Get("/files/:objectName", getFile)
+	api.Patch("/files/:objectName/expiry", updateFileExpiry)
+
+	// 实时事件推送：分片到达 / 文件上传完成 / 文件删除，替代前端轮询 upload/status
+	api.Get("/events", streamEvents)
+	api.Use("/events/ws", func(c *fiber.Ctx) error {
+		if websocket.IsWebSocketUpgrade(c) {
+			return c.Next()
+		}
+		return fiber.ErrUpgradeRequired
+	})
+	api.Get("/events/ws", websocket.New(streamEventsWS))
 
 	// 启动服务器
 	log.Printf("MinIO上传服务器运行在 http://localhost:%s", config.Port)
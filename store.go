@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	bolt "go.etcd.io/bbolt"
+)
+
+// uploadsBucket 是 bbolt 中存放上传记录的桶名
+const uploadsBucket = "uploads"
+
+// uploadManifestPrefix 是 MinIO 存储后端中清单对象的前缀
+const uploadManifestPrefix = ".uploads/"
+
+// UploadStore 持久化分片上传状态，使服务重启后能够恢复在途上传，
+// 并能找出长期未完成、需要清理的孤儿上传
+type UploadStore interface {
+	Create(info *MultipartUploadInfo) error
+	Get(uploadID string) (*MultipartUploadInfo, error)
+	MarkChunk(uploadID string, chunkIndex int) error
+	// SetExpiry 更新上传完成后最终对象的存活时间
+	SetExpiry(uploadID string, ttl time.Duration) error
+	Delete(uploadID string) error
+	// List 返回全部在途上传，用于启动时恢复内存状态
+	List() ([]*MultipartUploadInfo, error)
+	// ListStale 返回创建时间早于 olderThan 的上传，供清理任务使用
+	ListStale(olderThan time.Time) ([]*MultipartUploadInfo, error)
+}
+
+// boltUploadStore 是基于 bbolt 的本地文件存储实现
+type boltUploadStore struct {
+	db *bolt.DB
+}
+
+// newBoltUploadStore 打开（必要时创建）bbolt 数据库文件
+func newBoltUploadStore(path string) (*boltUploadStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开 bbolt 数据库失败: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(uploadsBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("初始化 bbolt 桶失败: %v", err)
+	}
+
+	return &boltUploadStore{db: db}, nil
+}
+
+func (s *boltUploadStore) Create(info *MultipartUploadInfo) error {
+	return s.put(info)
+}
+
+func (s *boltUploadStore) put(info *MultipartUploadInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("序列化上传记录失败: %v", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(uploadsBucket)).Put([]byte(info.UploadID), data)
+	})
+}
+
+func (s *boltUploadStore) Get(uploadID string) (*MultipartUploadInfo, error) {
+	var info *MultipartUploadInfo
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(uploadsBucket)).Get([]byte(uploadID))
+		if data == nil {
+			return nil
+		}
+		info = &MultipartUploadInfo{}
+		return json.Unmarshal(data, info)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, fmt.Errorf("上传记录不存在: %s", uploadID)
+	}
+
+	return info, nil
+}
+
+func (s *boltUploadStore) MarkChunk(uploadID string, chunkIndex int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(uploadsBucket))
+
+		data := bucket.Get([]byte(uploadID))
+		if data == nil {
+			return fmt.Errorf("上传记录不存在: %s", uploadID)
+		}
+
+		info := &MultipartUploadInfo{}
+		if err := json.Unmarshal(data, info); err != nil {
+			return fmt.Errorf("解析上传记录失败: %v", err)
+		}
+
+		if info.UploadedChunks == nil {
+			info.UploadedChunks = make(map[int]bool)
+		}
+		info.UploadedChunks[chunkIndex] = true
+
+		newData, err := json.Marshal(info)
+		if err != nil {
+			return fmt.Errorf("序列化上传记录失败: %v", err)
+		}
+
+		return bucket.Put([]byte(uploadID), newData)
+	})
+}
+
+func (s *boltUploadStore) SetExpiry(uploadID string, ttl time.Duration) error {
+	info, err := s.Get(uploadID)
+	if err != nil {
+		return err
+	}
+
+	info.ExpiresIn = ttl
+	return s.put(info)
+}
+
+func (s *boltUploadStore) Delete(uploadID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(uploadsBucket)).Delete([]byte(uploadID))
+	})
+}
+
+func (s *boltUploadStore) List() ([]*MultipartUploadInfo, error) {
+	var all []*MultipartUploadInfo
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(uploadsBucket)).ForEach(func(_, data []byte) error {
+			info := &MultipartUploadInfo{}
+			if err := json.Unmarshal(data, info); err != nil {
+				return err
+			}
+			all = append(all, info)
+			return nil
+		})
+	})
+
+	return all, err
+}
+
+func (s *boltUploadStore) ListStale(olderThan time.Time) ([]*MultipartUploadInfo, error) {
+	all, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []*MultipartUploadInfo
+	for _, info := range all {
+		if info.CreatedAt.Before(olderThan) {
+			stale = append(stale, info)
+		}
+	}
+
+	return stale, nil
+}
+
+// minioUploadStore 把上传记录作为 JSON 清单对象写入 MinIO 的 .uploads/ 前缀下，
+// 适合多实例部署时共享上传状态而不依赖本地磁盘
+type minioUploadStore struct {
+	client     *minio.Client
+	bucketName string
+
+	// markChunkMu 串行化 MarkChunk 的读-改-写，minio-go 没有现成的条件 PUT，
+	// 并发分片上传若各自独立 Get+put 会互相覆盖对方刚写入的 UploadedChunks
+	markChunkMu sync.Mutex
+}
+
+func newMinioUploadStore(client *minio.Client, bucketName string) *minioUploadStore {
+	return &minioUploadStore{client: client, bucketName: bucketName}
+}
+
+func (s *minioUploadStore) manifestKey(uploadID string) string {
+	return uploadManifestPrefix + uploadID + ".json"
+}
+
+func (s *minioUploadStore) Create(info *MultipartUploadInfo) error {
+	return s.put(info)
+}
+
+func (s *minioUploadStore) put(info *MultipartUploadInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("序列化上传清单失败: %v", err)
+	}
+
+	ctx := context.Background()
+	_, err = s.client.PutObject(ctx, s.bucketName, s.manifestKey(info.UploadID), strings.NewReader(string(data)), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return fmt.Errorf("写入上传清单失败: %v", err)
+	}
+
+	return nil
+}
+
+func (s *minioUploadStore) Get(uploadID string) (*MultipartUploadInfo, error) {
+	ctx := context.Background()
+	obj, err := s.client.GetObject(ctx, s.bucketName, s.manifestKey(uploadID), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("上传记录不存在: %s", uploadID)
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, fmt.Errorf("读取上传清单失败: %v", err)
+	}
+
+	info := &MultipartUploadInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("解析上传清单失败: %v", err)
+	}
+
+	return info, nil
+}
+
+func (s *minioUploadStore) MarkChunk(uploadID string, chunkIndex int) error {
+	s.markChunkMu.Lock()
+	defer s.markChunkMu.Unlock()
+
+	info, err := s.Get(uploadID)
+	if err != nil {
+		return err
+	}
+
+	if info.UploadedChunks == nil {
+		info.UploadedChunks = make(map[int]bool)
+	}
+	info.UploadedChunks[chunkIndex] = true
+
+	return s.put(info)
+}
+
+func (s *minioUploadStore) SetExpiry(uploadID string, ttl time.Duration) error {
+	info, err := s.Get(uploadID)
+	if err != nil {
+		return err
+	}
+
+	info.ExpiresIn = ttl
+	return s.put(info)
+}
+
+func (s *minioUploadStore) Delete(uploadID string) error {
+	ctx := context.Background()
+	return s.client.RemoveObject(ctx, s.bucketName, s.manifestKey(uploadID), minio.RemoveObjectOptions{})
+}
+
+func (s *minioUploadStore) List() ([]*MultipartUploadInfo, error) {
+	ctx := context.Background()
+	var all []*MultipartUploadInfo
+
+	objectCh := s.client.ListObjects(ctx, s.bucketName, minio.ListObjectsOptions{
+		Prefix:    uploadManifestPrefix,
+		Recursive: true,
+	})
+
+	for object := range objectCh {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+
+		obj, err := s.client.GetObject(ctx, s.bucketName, object.Key, minio.GetObjectOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("读取上传清单 %s 失败: %v", object.Key, err)
+		}
+
+		data, err := io.ReadAll(obj)
+		obj.Close()
+		if err != nil {
+			return nil, fmt.Errorf("读取上传清单 %s 失败: %v", object.Key, err)
+		}
+
+		info := &MultipartUploadInfo{}
+		if err := json.Unmarshal(data, info); err != nil {
+			return nil, fmt.Errorf("解析上传清单 %s 失败: %v", object.Key, err)
+		}
+		all = append(all, info)
+	}
+
+	return all, nil
+}
+
+func (s *minioUploadStore) ListStale(olderThan time.Time) ([]*MultipartUploadInfo, error) {
+	all, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []*MultipartUploadInfo
+	for _, info := range all {
+		if info.CreatedAt.Before(olderThan) {
+			stale = append(stale, info)
+		}
+	}
+
+	return stale, nil
+}
+
+// newUploadStore 根据配置选择上传状态存储的实现
+func newUploadStore(cfg *Config, client *minio.Client) (UploadStore, error) {
+	if cfg.UploadStoreBackend == "minio" {
+		return newMinioUploadStore(client, cfg.BucketName), nil
+	}
+
+	return newBoltUploadStore(cfg.UploadDBPath)
+}
+
+// rehydrateUploads 在启动时从持久化存储恢复在途上传到内存中的 multipartUploads
+func rehydrateUploads(store UploadStore) error {
+	records, err := store.List()
+	if err != nil {
+		return fmt.Errorf("恢复上传状态失败: %v", err)
+	}
+
+	uploadsMutex.Lock()
+	defer uploadsMutex.Unlock()
+
+	for _, info := range records {
+		multipartUploads[info.UploadID] = info
+		// 重启前建立的 objectName -> uploadId 索引只存在于内存，重启后要重新
+		// 建立，否则恢复的上传在完成前到达的分片事件（见 events.go）就丢了 uploadId
+		registerObjectUploadIndex(info.ObjectName, info.UploadID)
+	}
+
+	log.Printf("已从持久化存储恢复 %d 个在途上传", len(records))
+	return nil
+}
+
+// startUploadJanitor 周期性地清理超过 TTL 仍未完成的上传：
+// 删除已上传的分片对象和持久化记录，避免孤儿分片永久占用存储空间
+func startUploadJanitor(store UploadStore, interval, ttl time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for range ticker.C {
+			cleanupStaleUploads(store, ttl)
+		}
+	}()
+}
+
+func cleanupStaleUploads(store UploadStore, ttl time.Duration) {
+	pruneObjectUploadIndex(time.Now().Add(-ttl))
+
+	stale, err := store.ListStale(time.Now().Add(-ttl))
+	if err != nil {
+		log.Printf("扫描过期上传失败: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	for _, info := range stale {
+		log.Printf("清理过期上传: %s（文件: %s，创建于 %s）", info.UploadID, info.FileName, info.CreatedAt)
+
+		for i := 0; i < info.TotalChunks; i++ {
+			chunkObjectName := fmt.Sprintf("%s.part%d", info.ObjectName, i)
+			if err := minioClient.RemoveObject(ctx, config.BucketName, chunkObjectName, minio.RemoveObjectOptions{}); err != nil {
+				log.Printf("清理分片失败: %s, %v", chunkObjectName, err)
+			}
+		}
+
+		if err := store.Delete(info.UploadID); err != nil {
+			log.Printf("删除上传记录失败: %s, %v", info.UploadID, err)
+		}
+
+		uploadsMutex.Lock()
+		delete(multipartUploads, info.UploadID)
+		uploadsMutex.Unlock()
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestBoltStore(t *testing.T) *boltUploadStore {
+	t.Helper()
+
+	store, err := newBoltUploadStore(filepath.Join(t.TempDir(), "uploads.db"))
+	if err != nil {
+		t.Fatalf("newBoltUploadStore() error = %v", err)
+	}
+	t.Cleanup(func() { store.db.Close() })
+
+	return store
+}
+
+func TestBoltUploadStoreCreateGetDelete(t *testing.T) {
+	store := newTestBoltStore(t)
+
+	info := &MultipartUploadInfo{
+		UploadID:    "upload-1",
+		FileName:    "report.pdf",
+		ObjectName:  "1700000000_report.pdf",
+		TotalChunks: 3,
+		CreatedAt:   time.Now(),
+	}
+
+	if err := store.Create(info); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	got, err := store.Get(info.UploadID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.FileName != info.FileName || got.ObjectName != info.ObjectName {
+		t.Fatalf("Get() = %+v, want FileName/ObjectName matching %+v", got, info)
+	}
+
+	if err := store.Delete(info.UploadID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.Get(info.UploadID); err == nil {
+		t.Fatalf("Get() after Delete() should error")
+	}
+}
+
+func TestBoltUploadStoreMarkChunkConcurrent(t *testing.T) {
+	store := newTestBoltStore(t)
+
+	const totalChunks = 50
+	info := &MultipartUploadInfo{
+		UploadID:    "upload-concurrent",
+		FileName:    "big.bin",
+		ObjectName:  "1700000000_big.bin",
+		TotalChunks: totalChunks,
+		CreatedAt:   time.Now(),
+	}
+	if err := store.Create(info); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	// 并发 MarkChunk 曾经各自独立 Get+Put，互相覆盖对方刚写入的 UploadedChunks
+	// （f810091 修复的回归），这里并发标记所有分片，确保没有一个丢失
+	var wg sync.WaitGroup
+	for i := 0; i < totalChunks; i++ {
+		wg.Add(1)
+		go func(chunkIndex int) {
+			defer wg.Done()
+			if err := store.MarkChunk(info.UploadID, chunkIndex); err != nil {
+				t.Errorf("MarkChunk(%d) error = %v", chunkIndex, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	got, err := store.Get(info.UploadID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(got.UploadedChunks) != totalChunks {
+		t.Fatalf("UploadedChunks has %d entries, want %d", len(got.UploadedChunks), totalChunks)
+	}
+	for i := 0; i < totalChunks; i++ {
+		if !got.UploadedChunks[i] {
+			t.Fatalf("chunk %d missing from UploadedChunks", i)
+		}
+	}
+}
+
+func TestBoltUploadStoreListStale(t *testing.T) {
+	store := newTestBoltStore(t)
+
+	now := time.Now()
+	fresh := &MultipartUploadInfo{UploadID: "fresh", ObjectName: "fresh.bin", CreatedAt: now}
+	stale := &MultipartUploadInfo{UploadID: "stale", ObjectName: "stale.bin", CreatedAt: now.Add(-time.Hour)}
+
+	if err := store.Create(fresh); err != nil {
+		t.Fatalf("Create(fresh) error = %v", err)
+	}
+	if err := store.Create(stale); err != nil {
+		t.Fatalf("Create(stale) error = %v", err)
+	}
+
+	got, err := store.ListStale(now.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("ListStale() error = %v", err)
+	}
+	if len(got) != 1 || got[0].UploadID != "stale" {
+		t.Fatalf("ListStale() = %+v, want only %q", got, "stale")
+	}
+}